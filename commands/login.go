@@ -1,19 +1,30 @@
 package commands
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/concourse/atc"
 	"github.com/concourse/fly/rc"
 	"github.com/concourse/go-concourse/concourse"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
+// pkceUnreservedChars is the character set RFC 7636 permits in a code
+// verifier: unreserved URI characters (ALPHA / DIGIT / "-" / "." / "_" / "~").
+const pkceUnreservedChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-._~"
+
 type LoginCommand struct {
 	ATCURL   string       `short:"c" long:"concourse-url" description:"Concourse URL to authenticate with"`
 	Insecure bool         `short:"k" long:"insecure" description:"Skip verification of the endpoint's SSL certificate"`
@@ -21,6 +32,7 @@ type LoginCommand struct {
 	Password string       `short:"p" long:"password" description:"Password for basic auth"`
 	TeamName string       `short:"n" long:"team-name" description:"Team to authenticate with"`
 	CACert   atc.PathFlag `long:"ca-cert" description:"Path to Concourse PEM-encoded CA certificate file."`
+	Device   bool         `long:"device" description:"Use the OAuth device authorization flow instead of a browser redirect; auto-enabled when stdout isn't a terminal"`
 }
 
 func (command *LoginCommand) Execute(args []string) error {
@@ -80,13 +92,24 @@ func (command *LoginCommand) Execute(args []string) error {
 		return err
 	}
 
-	var tokenType string
-	var tokenValue string
+	var token *rc.TargetToken
 
 	if command.Username != "" && command.Password != "" {
-		tokenType, tokenValue, err = command.passwordGrant(client, command.Username, command.Password)
+		token, err = command.passwordGrant(client, command.Username, command.Password)
+	} else if command.Device || !terminal.IsTerminal(int(os.Stdout.Fd())) {
+		var httpClient *http.Client
+		httpClient, err = rc.HTTPClientFor(command.Insecure, caCert)
+		if err != nil {
+			return err
+		}
+		token, err = command.deviceGrant(httpClient, client.URL())
 	} else {
-		tokenType, tokenValue, err = command.authCodeGrant(client.URL())
+		var httpClient *http.Client
+		httpClient, err = rc.HTTPClientFor(command.Insecure, caCert)
+		if err != nil {
+			return err
+		}
+		token, err = command.authCodeGrant(httpClient, client.URL())
 	}
 	if err != nil {
 		return err
@@ -96,34 +119,44 @@ func (command *LoginCommand) Execute(args []string) error {
 
 	return command.saveTarget(
 		client.URL(),
-		&rc.TargetToken{
-			Type:  tokenType,
-			Value: tokenValue,
-		},
+		token,
 		target.CACert(),
 	)
 }
 
-func (command *LoginCommand) passwordGrant(client concourse.Client, username, password string) (string, string, error) {
-
+func (command *LoginCommand) passwordGrant(client concourse.Client, username, password string) (*rc.TargetToken, error) {
 	token, err := client.PasswordGrant(username, password)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	return token.TokenType, token.AccessToken, nil
+	return &rc.TargetToken{
+		Type:  token.TokenType,
+		Value: token.AccessToken,
+	}, nil
 }
 
-func (command *LoginCommand) authCodeGrant(targetUrl string) (string, string, error) {
+func (command *LoginCommand) authCodeGrant(httpClient *http.Client, targetUrl string) (*rc.TargetToken, error) {
+	codeVerifier, err := generatePKCECodeVerifier()
+	if err != nil {
+		return nil, err
+	}
+
+	codeChallenge := pkceCodeChallenge(codeVerifier)
+
+	state, err := randomPKCEString(32)
+	if err != nil {
+		return nil, err
+	}
 
 	var tokenStr string
 
 	stdinChannel := make(chan string)
-	tokenChannel := make(chan string)
+	codeChannel := make(chan string)
 	errorChannel := make(chan error)
 	portChannel := make(chan string)
 
-	go listenForTokenCallback(tokenChannel, errorChannel, portChannel, targetUrl)
+	go listenForTokenCallback(codeChannel, errorChannel, portChannel, targetUrl, state)
 
 	port := <-portChannel
 
@@ -132,32 +165,247 @@ func (command *LoginCommand) authCodeGrant(targetUrl string) (string, string, er
 		panic(err)
 	}
 
+	loginUrl := url.Values{
+		"redirect":              {redirectUrl.String()},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+		"state":                 {state},
+	}
+
 	fmt.Println("navigate to the following URL in your browser:")
 	fmt.Println("")
-	fmt.Printf("    %s/sky/login?redirect=%s\n", targetUrl, redirectUrl.String())
+	fmt.Printf("    %s/sky/login?%s\n", targetUrl, loginUrl.Encode())
 	fmt.Println("")
 
 	go waitForTokenInput(stdinChannel, errorChannel)
 
 	select {
-	case tokenStrMsg := <-tokenChannel:
-		tokenStr = tokenStrMsg
+	case code := <-codeChannel:
+		return exchangeAuthCode(httpClient, targetUrl, code, codeVerifier, redirectUrl.String())
 	case tokenStrMsg := <-stdinChannel:
 		tokenStr = tokenStrMsg
 	case errorMsg := <-errorChannel:
-		return "", "", errorMsg
+		return nil, errorMsg
 	}
 
 	segments := strings.SplitN(tokenStr, " ", 2)
 
-	return segments[0], segments[1], nil
+	return &rc.TargetToken{
+		Type:  segments[0],
+		Value: segments[1],
+	}, nil
+}
+
+func generatePKCECodeVerifier() (string, error) {
+	return randomPKCEString(64)
+}
+
+func randomPKCEString(length int) (string, error) {
+	bytes := make([]byte, length)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+
+	verifier := make([]byte, length)
+	for i, b := range bytes {
+		verifier[i] = pkceUnreservedChars[int(b)%len(pkceUnreservedChars)]
+	}
+
+	return string(verifier), nil
 }
 
-func listenForTokenCallback(tokenChannel chan string, errorChannel chan error, portChannel chan string, targetUrl string) {
+func pkceCodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// errDeviceAuthorizationPending and errDeviceSlowDown are the two non-terminal
+// "error" values the device token endpoint returns while polling, per RFC 8628
+// section 3.5. Anything else (access_denied, expired_token, ...) is terminal.
+var (
+	errDeviceAuthorizationPending = errors.New("authorization_pending")
+	errDeviceSlowDown             = errors.New("slow_down")
+)
+
+type deviceCodeResponse struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+
+	// Interval is a pointer so an explicit 0 (poll immediately) can be told
+	// apart from the field being left out of the response entirely, per
+	// RFC 8628 section 3.2's "if no value is provided, clients MUST use 5".
+	Interval  *int `json:"interval"`
+	ExpiresIn int  `json:"expires_in"`
+}
+
+// tokenResponse is the shape of a successful or pending response from the
+// ATC's /sky/issuer/token endpoint, shared by every grant that exchanges
+// something (an auth code, a device code) for a token there.
+type tokenResponse struct {
+	Error        string `json:"error"`
+	TokenType    string `json:"token_type"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func (t tokenResponse) targetToken() *rc.TargetToken {
+	token := &rc.TargetToken{
+		Type:         t.TokenType,
+		Value:        t.AccessToken,
+		RefreshToken: t.RefreshToken,
+	}
+
+	if t.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+	}
+
+	return token
+}
+
+// exchangeAuthCode completes the authorization_code leg of the PKCE flow
+// authCodeGrant started: the code it received on the loopback callback, plus
+// the code_verifier only this process knows, are exchanged for a token
+// directly against the ATC's token endpoint.
+func exchangeAuthCode(httpClient *http.Client, targetUrl, code, codeVerifier, redirectURI string) (*rc.TargetToken, error) {
+	resp, err := httpClient.PostForm(targetUrl+"/sky/issuer/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"code_verifier": {codeVerifier},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {"fly"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+
+	if token.Error != "" {
+		return nil, fmt.Errorf("code exchange failed: %s", token.Error)
+	}
+
+	return token.targetToken(), nil
+}
+
+// deviceGrant implements the OAuth 2.0 Device Authorization Grant (RFC 8628),
+// for targets where a local loopback redirect can't be reached (CI workers,
+// containers, SSH sessions without port forwarding). It talks to the ATC's
+// device code and token endpoints directly rather than through
+// concourse.Client, which has no notion of this flow.
+func (command *LoginCommand) deviceGrant(httpClient *http.Client, targetUrl string) (*rc.TargetToken, error) {
+	deviceCode, err := requestDeviceCode(httpClient, targetUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("to continue logging in, open the following URL in a browser:")
+	fmt.Println("")
+	fmt.Printf("    %s\n", deviceCode.VerificationURI)
+	fmt.Println("")
+	fmt.Printf("and enter the code: %s\n", deviceCode.UserCode)
+	fmt.Println("")
+
+	interval := 5 * time.Second
+	if deviceCode.Interval != nil {
+		interval = time.Duration(*deviceCode.Interval) * time.Second
+	}
+
+	deadline := time.Now().Add(time.Duration(deviceCode.ExpiresIn) * time.Second)
+
+	for {
+		if time.Now().After(deadline) {
+			return nil, errors.New("device code expired before login was completed")
+		}
+
+		time.Sleep(interval)
+
+		token, err := pollDeviceToken(httpClient, targetUrl, deviceCode.DeviceCode)
+		switch err {
+		case nil:
+			return token, nil
+		case errDeviceAuthorizationPending:
+			continue
+		case errDeviceSlowDown:
+			interval += 5 * time.Second
+			continue
+		default:
+			return nil, err
+		}
+	}
+}
+
+func requestDeviceCode(httpClient *http.Client, targetUrl string) (*deviceCodeResponse, error) {
+	resp, err := httpClient.PostForm(targetUrl+"/sky/device/code", url.Values{
+		"client_id": {"fly"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("device code request failed: %s: %s", resp.Status, string(body))
+	}
+
+	var deviceCode deviceCodeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&deviceCode); err != nil {
+		return nil, err
+	}
+
+	return &deviceCode, nil
+}
+
+func pollDeviceToken(httpClient *http.Client, targetUrl, deviceCode string) (*rc.TargetToken, error) {
+	resp, err := httpClient.PostForm(targetUrl+"/sky/issuer/token", url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {deviceCode},
+		"client_id":   {"fly"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+
+	switch token.Error {
+	case "":
+		return token.targetToken(), nil
+	case "authorization_pending":
+		return nil, errDeviceAuthorizationPending
+	case "slow_down":
+		return nil, errDeviceSlowDown
+	default:
+		return nil, fmt.Errorf("device login failed: %s", token.Error)
+	}
+}
+
+func listenForTokenCallback(codeChannel chan string, errorChannel chan error, portChannel chan string, targetUrl string, state string) {
 	s := &http.Server{
 		Addr: "127.0.0.1:0",
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			tokenChannel <- r.FormValue("token")
+			if !refererOrOriginMatches(r, targetUrl) {
+				http.Error(w, "request did not come from the expected Concourse URL", http.StatusForbidden)
+				return
+			}
+
+			if r.FormValue("state") != state {
+				http.Error(w, "state did not match the one fly generated for this login", http.StatusForbidden)
+				return
+			}
+
+			codeChannel <- r.FormValue("code")
 			http.Redirect(w, r, fmt.Sprintf("%s/public/fly_success", targetUrl), http.StatusTemporaryRedirect)
 		}),
 	}
@@ -169,6 +417,41 @@ func listenForTokenCallback(tokenChannel chan string, errorChannel chan error, p
 	}
 }
 
+// refererOrOriginMatches guards the loopback callback against a local
+// process that isn't the browser we redirected to the configured ATC: if
+// either header is present, it must say it came from that ATC's origin.
+//
+// Neither header being present is tolerated rather than rejected: the
+// normal case is an HTTPS ATC redirecting to this plain-HTTP loopback, and
+// browsers drop Referer on an HTTPS-to-HTTP navigation and never send
+// Origin on a simple top-level GET redirect. That leaves the state
+// parameter as the check that actually matters for that request; this one
+// only catches a present-but-wrong header.
+func refererOrOriginMatches(r *http.Request, targetUrl string) bool {
+	target, err := url.Parse(targetUrl)
+	if err != nil {
+		return false
+	}
+
+	for _, header := range []string{"Referer", "Origin"} {
+		value := r.Header.Get(header)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := url.Parse(value)
+		if err != nil {
+			return false
+		}
+
+		if parsed.Scheme != target.Scheme || parsed.Host != target.Host {
+			return false
+		}
+	}
+
+	return true
+}
+
 func listenAndServeWithPort(srv *http.Server, portChannel chan string) error {
 	addr := srv.Addr
 	ln, err := net.Listen("tcp", addr)
@@ -218,10 +501,7 @@ func (command *LoginCommand) saveTarget(url string, token *rc.TargetToken, caCer
 		url,
 		command.Insecure,
 		command.TeamName,
-		&rc.TargetToken{
-			Type:  token.Type,
-			Value: token.Value,
-		},
+		token,
 		caCert,
 	)
 	if err != nil {
@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/concourse/fly/rc"
+)
+
+type LogoutCommand struct {
+	All     bool `short:"a" long:"all" description:"Logout of all targets, concurrently"`
+	Expired bool `long:"expired" description:"Only logout targets whose sessions the server has already invalidated"`
+}
+
+func (command *LogoutCommand) Execute(args []string) error {
+	switch {
+	case command.Expired:
+		return command.logoutExpired()
+	case command.All:
+		return command.logoutAll()
+	default:
+		return logoutTarget(Fly.Target, Fly.Verbose)
+	}
+}
+
+// logoutTarget revokes the target's token server-side (best-effort -- an
+// unreachable or already-invalid token shouldn't block removing the local
+// entry) and then forgets the target.
+func logoutTarget(name rc.TargetName, verbose bool) error {
+	target, err := rc.LoadTarget(name, verbose)
+	if err != nil {
+		return err
+	}
+
+	if token := target.Token(); token != nil {
+		if err := revokeToken(target, token.Value); err != nil {
+			fmt.Printf("%s: warning: failed to revoke token: %s\n", name, err)
+		}
+	}
+
+	return rc.RemoveTarget(name)
+}
+
+// revokeToken asks the ATC to invalidate token server-side via
+// /sky/token/revoke (RFC 7009). concourse.Client has no notion of this
+// endpoint, so it's called directly, the same way deviceGrant in login.go
+// talks to /sky/issuer/token.
+func revokeToken(target rc.Target, token string) error {
+	resp, err := target.HTTPClient().PostForm(target.Client().URL()+"/sky/token/revoke", url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revoke request failed: %s", resp.Status)
+	}
+
+	return nil
+}
+
+type logoutResult struct {
+	name rc.TargetName
+	err  error
+}
+
+// logoutAll iterates every saved target concurrently, reporting per-target
+// success or failure without letting one bad target abort the rest.
+func (command *LogoutCommand) logoutAll() error {
+	targets, err := rc.LoadTargets()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan logoutResult, len(targets))
+
+	for name := range targets {
+		wg.Add(1)
+		go func(name rc.TargetName) {
+			defer wg.Done()
+			results <- logoutResult{name: name, err: logoutTarget(name, Fly.Verbose)}
+		}(name)
+	}
+
+	wg.Wait()
+	close(results)
+
+	failed := false
+	for result := range results {
+		if result.err != nil {
+			failed = true
+			fmt.Printf("%s: failed to logout: %s\n", result.name, result.err)
+		} else {
+			fmt.Printf("%s: logged out\n", result.name)
+		}
+	}
+
+	if failed {
+		return fmt.Errorf("failed to logout of one or more targets")
+	}
+
+	return nil
+}
+
+// logoutExpired only removes targets whose sessions the ATC has already
+// invalidated, as determined by a UserInfo probe (after giving the target a
+// chance to refresh its token).
+func (command *LogoutCommand) logoutExpired() error {
+	targets, err := rc.LoadTargets()
+	if err != nil {
+		return err
+	}
+
+	for name := range targets {
+		target, err := rc.LoadTarget(name, Fly.Verbose)
+		if err != nil {
+			fmt.Printf("%s: couldn't load target: %s\n", name, err)
+			continue
+		}
+
+		_, err = target.Client().UserInfo()
+		if err == nil {
+			continue
+		}
+
+		logoutErr := logoutTarget(name, Fly.Verbose)
+		if logoutErr != nil {
+			fmt.Printf("%s: failed to logout expired target: %s\n", name, logoutErr)
+			continue
+		}
+
+		fmt.Printf("%s: logged out (expired)\n", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,314 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/concourse/atc"
+	"github.com/concourse/fly/rc"
+	"gopkg.in/yaml.v2"
+)
+
+type SetTeamCommand struct {
+	TeamName string `short:"n" long:"team-name" required:"true" description:"Team to create or modify"`
+
+	SkipInteractive bool `long:"non-interactive" description:"Force apply configuration"`
+	AllowNoAuth     bool `long:"no-really-i-dont-want-any-auth" description:"Explicitly allow a team with no auth methods configured"`
+
+	LocalUsers   []string `long:"local-user" description:"A whitelisted local concourse user"`
+	GitHubGroups []string `long:"github-group" description:"A whitelisted GitHub org, org:team, or email"`
+	GitHubUsers  []string `long:"github-user" description:"A whitelisted GitHub user"`
+	GitLabGroups []string `long:"gitlab-group" description:"A whitelisted GitLab group"`
+	CFGroups     []string `long:"cf-group" description:"A whitelisted CF org:space"`
+	OAuthGroups  []string `long:"oauth-group" description:"A whitelisted Generic OAuth group"`
+
+	ConfigFile string `short:"c" long:"config" description:"A YAML/JSON file mirroring atc.Team.Auth, for declarative team management"`
+	DryRun     bool   `long:"dry-run" description:"Print the diff against the current team config without applying it"`
+}
+
+// teamRole mirrors atc.TeamRole: the set of users/groups granted a role.
+type teamRole struct {
+	Users  []string `yaml:"users" json:"users"`
+	Groups []string `yaml:"groups" json:"groups"`
+}
+
+// teamAuthConfig mirrors atc.Team.Auth as exposed over the API: a role name
+// (owner/member/viewer) to the principals granted it.
+type teamAuthConfig struct {
+	Roles map[string]teamRole `yaml:"roles" json:"roles"`
+}
+
+func (command *SetTeamCommand) Execute(args []string) error {
+	if len(args) != 0 {
+		return errors.New("unexpected argument [" + strings.Join(args, ", ") + "]")
+	}
+
+	target, err := rc.LoadTarget(Fly.Target, Fly.Verbose)
+	if err != nil {
+		return err
+	}
+
+	var auth teamAuthConfig
+	if command.ConfigFile != "" {
+		auth, err = loadTeamAuthConfig(command.ConfigFile)
+		if err != nil {
+			return err
+		}
+	} else {
+		auth = command.flagsTeamAuthConfig()
+	}
+
+	if noAuthConfigured(auth) && !command.AllowNoAuth {
+		return errors.New(
+			"no auth methods configured! to continue, run:\n\n" +
+				fmt.Sprintf("    fly -t %s set-team -n %s --no-really-i-dont-want-any-auth\n\n", Fly.Target, command.TeamName) +
+				"this will leave the team open to anyone to mess with!",
+		)
+	}
+
+	if command.DryRun {
+		return command.dryRun(target, auth)
+	}
+
+	command.display(auth)
+
+	if !command.confirm() {
+		return errors.New("bailing out")
+	}
+
+	client := target.Client()
+
+	team := atc.Team{
+		Name: command.TeamName,
+		Auth: authConfigToAtc(auth),
+	}
+
+	_, found, err := client.Team(command.TeamName).CreateOrUpdate(team)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		fmt.Println("team updated")
+	} else {
+		fmt.Println("team created")
+	}
+
+	return nil
+}
+
+func (command *SetTeamCommand) flagsTeamAuthConfig() teamAuthConfig {
+	var users, groups []string
+	for _, u := range command.LocalUsers {
+		users = append(users, "local:"+u)
+	}
+	for _, u := range command.GitHubUsers {
+		users = append(users, "github:"+u)
+	}
+	for _, g := range command.GitHubGroups {
+		groups = append(groups, "github:"+g)
+	}
+	for _, g := range command.GitLabGroups {
+		groups = append(groups, "gitlab:"+g)
+	}
+	for _, g := range command.CFGroups {
+		groups = append(groups, "cf:"+g)
+	}
+	for _, g := range command.OAuthGroups {
+		groups = append(groups, "oauth:"+g)
+	}
+
+	return teamAuthConfig{
+		Roles: map[string]teamRole{
+			"owner": {Users: users, Groups: groups},
+		},
+	}
+}
+
+// noAuthConfigured reports whether auth grants nobody anything, whichever
+// role(s) it names -- the state set-team must refuse to apply without
+// AllowNoAuth, since it leaves the team open to anyone.
+func noAuthConfigured(auth teamAuthConfig) bool {
+	for _, role := range auth.Roles {
+		if len(role.Users) > 0 || len(role.Groups) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func loadTeamAuthConfig(path string) (teamAuthConfig, error) {
+	var auth teamAuthConfig
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return auth, err
+	}
+
+	err = yaml.Unmarshal(content, &auth)
+	if err != nil {
+		return auth, err
+	}
+
+	return auth, nil
+}
+
+func authConfigToAtc(auth teamAuthConfig) atc.TeamAuth {
+	result := atc.TeamAuth{}
+
+	for role, principals := range auth.Roles {
+		result[role] = atc.TeamRole{
+			Users:  principals.Users,
+			Groups: principals.Groups,
+		}
+	}
+
+	return result
+}
+
+func (command *SetTeamCommand) display(auth teamAuthConfig) {
+	fmt.Printf("Team Name: %s\n\n", command.TeamName)
+
+	var sortedRoles []string
+	for role := range auth.Roles {
+		sortedRoles = append(sortedRoles, role)
+	}
+	sort.Strings(sortedRoles)
+
+	for _, role := range sortedRoles {
+		principals := auth.Roles[role]
+
+		fmt.Printf("role: %s\n", role)
+
+		fmt.Println("  groups:")
+		if len(principals.Groups) == 0 {
+			fmt.Println("    - none")
+		}
+		for _, g := range principals.Groups {
+			fmt.Printf("    - %s\n", g)
+		}
+
+		fmt.Println("  users:")
+		if len(principals.Users) == 0 {
+			fmt.Println("    - none")
+		}
+		for _, u := range principals.Users {
+			fmt.Printf("    - %s\n", u)
+		}
+	}
+
+	if noAuthConfigured(auth) {
+		fmt.Fprintln(os.Stderr, "\nWARNING:\nno auth methods configured. you asked for it!")
+	}
+}
+
+func (command *SetTeamCommand) confirm() bool {
+	if command.SkipInteractive {
+		return true
+	}
+
+	fmt.Printf("apply configuration? [yN]: ")
+
+	reader := bufio.NewReader(os.Stdin)
+	answer, _ := reader.ReadString('\n')
+	answer = strings.TrimSpace(strings.ToLower(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+// dryRun fetches the team's current auth config from the ATC and prints a
+// stable added/removed diff against the requested one, without PUTting
+// anything. This is what makes --config safe to run in CI for review.
+func (command *SetTeamCommand) dryRun(target rc.Target, desired teamAuthConfig) error {
+	client := target.Client()
+
+	existingTeam, found, err := client.Team(command.TeamName).Get()
+	if err != nil {
+		return err
+	}
+
+	var existing teamAuthConfig
+	if found {
+		existing = teamAuthConfig{Roles: map[string]teamRole{}}
+		for role, r := range existingTeam.Auth {
+			existing.Roles[role] = teamRole{Users: r.Users, Groups: r.Groups}
+		}
+	}
+
+	fmt.Printf("Team Name: %s\n\n", command.TeamName)
+
+	roles := map[string]bool{}
+	for role := range existing.Roles {
+		roles[role] = true
+	}
+	for role := range desired.Roles {
+		roles[role] = true
+	}
+
+	var sortedRoles []string
+	for role := range roles {
+		sortedRoles = append(sortedRoles, role)
+	}
+	sort.Strings(sortedRoles)
+
+	for _, role := range sortedRoles {
+		printRoleDiff(role, existing.Roles[role], desired.Roles[role])
+	}
+
+	return nil
+}
+
+func printRoleDiff(role string, before, after teamRole) {
+	addedUsers, removedUsers := diffStrings(before.Users, after.Users)
+	addedGroups, removedGroups := diffStrings(before.Groups, after.Groups)
+
+	if len(addedUsers) == 0 && len(removedUsers) == 0 && len(addedGroups) == 0 && len(removedGroups) == 0 {
+		return
+	}
+
+	fmt.Printf("role: %s\n", role)
+	for _, u := range addedUsers {
+		fmt.Printf("  + user %s\n", u)
+	}
+	for _, u := range removedUsers {
+		fmt.Printf("  - user %s\n", u)
+	}
+	for _, g := range addedGroups {
+		fmt.Printf("  + group %s\n", g)
+	}
+	for _, g := range removedGroups {
+		fmt.Printf("  - group %s\n", g)
+	}
+}
+
+func diffStrings(before, after []string) (added []string, removed []string) {
+	beforeSet := map[string]bool{}
+	for _, b := range before {
+		beforeSet[b] = true
+	}
+
+	afterSet := map[string]bool{}
+	for _, a := range after {
+		afterSet[a] = true
+		if !beforeSet[a] {
+			added = append(added, a)
+		}
+	}
+
+	for _, b := range before {
+		if !afterSet[b] {
+			removed = append(removed, b)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
@@ -0,0 +1,30 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/concourse/fly/rc"
+)
+
+type StatusCommand struct{}
+
+// Execute reports whether the current target is logged in. It doesn't trust
+// the token's contents -- some Concourse/Dex deployments issue opaque
+// tokens, and a local signing-key rotation would otherwise make a perfectly
+// valid token look expired -- so it asks the ATC directly via UserInfo.
+func (command *StatusCommand) Execute(args []string) error {
+	target, err := rc.LoadTarget(Fly.Target, Fly.Verbose)
+	if err != nil {
+		return err
+	}
+
+	_, err = target.Client().UserInfo()
+	if err != nil {
+		fmt.Printf("logged out\n")
+		return err
+	}
+
+	fmt.Printf("logged in successfully\n")
+
+	return nil
+}
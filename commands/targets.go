@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/concourse/fly/rc"
+)
+
+const pruneDialTimeout = 5 * time.Second
+
+type TargetsCommand struct {
+	SetCredentialStore string `long:"set-credential-store" description:"Name of a fly-credential-<name> helper to delegate target token storage to, e.g. 'osxkeychain'"`
+	Prune              bool   `long:"prune" description:"Delete targets whose ATC URL no longer resolves or accepts connections"`
+}
+
+func (command *TargetsCommand) Execute(args []string) error {
+	if command.SetCredentialStore != "" {
+		err := rc.SetCredentialStore(command.SetCredentialStore)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("credential store set to '%s'\n", command.SetCredentialStore)
+		return nil
+	}
+
+	if command.Prune {
+		return command.prune()
+	}
+
+	targets, err := rc.LoadTargets()
+	if err != nil {
+		return err
+	}
+
+	credentialStoreBacked, err := rc.CredentialStoreBackedTargets()
+	if err != nil {
+		return err
+	}
+
+	for name, props := range targets {
+		source := "flyrc"
+		if credentialStoreBacked[name] {
+			source = "credential store"
+		}
+
+		fmt.Printf("%s\t%s\t%s\t%s\n", name, props.API, props.TeamName, source)
+	}
+
+	return nil
+}
+
+// prune removes targets whose ATC is unreachable -- DNS failed to resolve
+// it, the connection was refused, or nothing answered the connection within
+// pruneDialTimeout -- which is
+// the common end state of an ephemeral Concourse environment that was torn
+// down without anyone running `fly logout`.
+func (command *TargetsCommand) prune() error {
+	targets, err := rc.LoadTargets()
+	if err != nil {
+		return err
+	}
+
+	for name, props := range targets {
+		if targetReachable(props.API) {
+			continue
+		}
+
+		err := rc.RemoveTarget(name)
+		if err != nil {
+			fmt.Printf("%s: failed to remove: %s\n", name, err)
+			continue
+		}
+
+		fmt.Printf("%s: pruned (unreachable)\n", name)
+	}
+
+	return nil
+}
+
+func targetReachable(api string) bool {
+	parsed, err := url.Parse(api)
+	if err != nil {
+		return false
+	}
+
+	host := parsed.Host
+	if parsed.Port() == "" {
+		if parsed.Scheme == "https" {
+			host = net.JoinHostPort(parsed.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(parsed.Hostname(), "80")
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", host, pruneDialTimeout)
+	if err != nil {
+		return false
+	}
+
+	conn.Close()
+
+	return true
+}
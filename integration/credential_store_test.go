@@ -0,0 +1,120 @@
+package integration_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/concourse/fly/rc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("fly targets --set-credential-store", func() {
+	It("records the helper name in the flyrc", func() {
+		flyCmd := exec.Command(flyPath, "targets", "--set-credential-store=test-helper")
+
+		sess, err := gexec.Start(flyCmd, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(sess).Should(gexec.Exit(0))
+
+		home, err := os.UserHomeDir()
+		Expect(err).ToNot(HaveOccurred())
+
+		content, err := ioutil.ReadFile(filepath.Join(home, ".flyrc"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(ContainSubstring("credential_store: test-helper"))
+	})
+})
+
+// fly-credential-fake is a fake helper (integration/fixtures/credential_helper)
+// that speaks the real store/get/erase/list protocol against a JSON file
+// instead of a real keychain, so these exercise the actual delegation rather
+// than just the flyrc field write above.
+var _ = Describe("delegating tokens to a fly-credential-* helper", func() {
+	var (
+		binDir    string
+		statePath string
+		env       []string
+	)
+
+	BeforeEach(func() {
+		helperPath, err := gexec.Build("github.com/concourse/fly/integration/fixtures/credential_helper")
+		Expect(err).ToNot(HaveOccurred())
+
+		binDir, err = ioutil.TempDir("", "fly-credential-fake")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(os.Rename(helperPath, filepath.Join(binDir, "fly-credential-fake"))).To(Succeed())
+
+		stateFile, err := ioutil.TempFile("", "fake-credential-store")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stateFile.Close()).To(Succeed())
+		statePath = stateFile.Name()
+
+		env = append(os.Environ(),
+			"PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH"),
+			"FAKE_CREDENTIAL_STORE_FILE="+statePath,
+		)
+
+		flyCmd := exec.Command(flyPath, "targets", "--set-credential-store=fake")
+		flyCmd.Env = env
+
+		sess, err := gexec.Start(flyCmd, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(sess).Should(gexec.Exit(0))
+
+		// rc.SaveTarget below execs fly-credential-fake itself, so the test
+		// process needs the same PATH/state-file env the fly subprocesses got.
+		Expect(os.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))).To(Succeed())
+		Expect(os.Setenv("FAKE_CREDENTIAL_STORE_FILE", statePath)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(binDir)
+		os.Remove(statePath)
+		os.Unsetenv("FAKE_CREDENTIAL_STORE_FILE")
+	})
+
+	It("stores the token via the helper instead of the flyrc, and lists it back", func() {
+		err := rc.SaveTarget(
+			targetName,
+			atcServer.URL(),
+			true,
+			"main",
+			&rc.TargetToken{Type: "Bearer", Value: "some-token"},
+			"",
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		home, err := os.UserHomeDir()
+		Expect(err).ToNot(HaveOccurred())
+
+		flyrc, err := ioutil.ReadFile(filepath.Join(home, ".flyrc"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(flyrc)).ToNot(ContainSubstring("some-token"))
+
+		state, err := ioutil.ReadFile(statePath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(state)).To(ContainSubstring("fly-target://" + string(targetName)))
+
+		loaded, err := rc.LoadTarget(targetName, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.Token().Value).To(Equal("some-token"))
+
+		backed, err := rc.CredentialStoreBackedTargets()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backed[targetName]).To(BeTrue())
+
+		targetsCmd := exec.Command(flyPath, "targets")
+		targetsCmd.Env = env
+
+		sess, err := gexec.Start(targetsCmd, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(sess.Out).Should(gbytes.Say("credential store"))
+		Eventually(sess).Should(gexec.Exit(0))
+	})
+})
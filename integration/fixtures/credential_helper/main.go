@@ -0,0 +1,90 @@
+// Command credential_helper is a fake fly-credential-* helper for
+// integration tests: it speaks the same store/get/erase/list protocol a real
+// helper (osxkeychain, secret-service, ...) would, persisting entries to the
+// JSON file named by FAKE_CREDENTIAL_STORE_FILE instead of a real keychain.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+type credentialEntry struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+func main() {
+	statePath := os.Getenv("FAKE_CREDENTIAL_STORE_FILE")
+	if statePath == "" || len(os.Args) != 2 {
+		os.Exit(1)
+	}
+
+	store := loadStore(statePath)
+
+	switch os.Args[1] {
+	case "store":
+		var entry credentialEntry
+		if err := json.NewDecoder(os.Stdin).Decode(&entry); err != nil {
+			os.Exit(1)
+		}
+		store[entry.ServerURL] = entry
+		saveStore(statePath, store)
+
+	case "get":
+		serverURL, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			os.Exit(1)
+		}
+		entry, found := store[string(serverURL)]
+		if !found {
+			os.Exit(1)
+		}
+		json.NewEncoder(os.Stdout).Encode(entry)
+
+	case "erase":
+		serverURL, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			os.Exit(1)
+		}
+		delete(store, string(serverURL))
+		saveStore(statePath, store)
+
+	case "list":
+		result := map[string]string{}
+		for serverURL, entry := range store {
+			result[serverURL] = entry.Username
+		}
+		json.NewEncoder(os.Stdout).Encode(result)
+
+	default:
+		os.Exit(1)
+	}
+}
+
+func loadStore(path string) map[string]credentialEntry {
+	store := map[string]credentialEntry{}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return store
+	}
+
+	json.Unmarshal(content, &store)
+
+	return store
+}
+
+func saveStore(path string, store map[string]credentialEntry) {
+	content, err := json.Marshal(store)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	err = ioutil.WriteFile(path, content, 0600)
+	if err != nil {
+		os.Exit(1)
+	}
+}
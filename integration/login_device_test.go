@@ -0,0 +1,79 @@
+package integration_test
+
+import (
+	"net/http"
+	"os/exec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("fly login --device", func() {
+	var flyCmd *exec.Cmd
+
+	JustBeforeEach(func() {
+		flyCmd = exec.Command(flyPath, "-t", targetName, "login", "--device", "-c", atcServer.URL())
+	})
+
+	Context("when the device code is approved on the first poll", func() {
+		BeforeEach(func() {
+			atcServer.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/sky/device/code"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+						"device_code":      "some-device-code",
+						"user_code":        "ABCD-EFGH",
+						"verification_uri": atcServer.URL() + "/sky/device",
+						"interval":         0,
+						"expires_in":       600,
+					}),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/sky/issuer/token"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+						"token_type":   "Bearer",
+						"access_token": "some-access-token",
+					}),
+				),
+			)
+		})
+
+		It("prints the user code and verification URI, then saves the token", func() {
+			sess, err := gexec.Start(flyCmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(sess.Out).Should(gbytes.Say("ABCD-EFGH"))
+			Eventually(sess.Out).Should(gbytes.Say("target saved"))
+
+			Eventually(sess).Should(gexec.Exit(0))
+		})
+	})
+
+	Context("when the device code expires before the user completes login", func() {
+		BeforeEach(func() {
+			atcServer.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/sky/device/code"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+						"device_code":      "some-device-code",
+						"user_code":        "ABCD-EFGH",
+						"verification_uri": atcServer.URL() + "/sky/device",
+						"interval":         0,
+						"expires_in":       0,
+					}),
+				),
+			)
+		})
+
+		It("reports the expiry and exits 1", func() {
+			sess, err := gexec.Start(flyCmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(sess.Err).Should(gbytes.Say("device code expired"))
+			Eventually(sess).Should(gexec.Exit(1))
+		})
+	})
+})
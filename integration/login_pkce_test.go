@@ -0,0 +1,125 @@
+package integration_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var redirectPortPattern = regexp.MustCompile(`redirect=http%3A%2F%2F127\.0\.0\.1%3A(\d+)%2Fauth%2Fcallback`)
+var statePattern = regexp.MustCompile(`[?&]state=([^&\s]+)`)
+
+var _ = Describe("fly login loopback callback", func() {
+	var flyCmd *exec.Cmd
+
+	JustBeforeEach(func() {
+		flyCmd = exec.Command(flyPath, "-t", targetName, "login", "-c", atcServer.URL())
+	})
+
+	loopbackPort := func(sess *gexec.Session) string {
+		Eventually(sess.Out).Should(gbytes.Say(`redirect=http%3A%2F%2F127\.0\.0\.1%3A\d+%2Fauth%2Fcallback`))
+		matches := redirectPortPattern.FindSubmatch(sess.Out.Contents())
+		Expect(matches).To(HaveLen(2))
+		return string(matches[1])
+	}
+
+	loginState := func(sess *gexec.Session) string {
+		matches := statePattern.FindSubmatch(sess.Out.Contents())
+		Expect(matches).To(HaveLen(2))
+		return string(matches[1])
+	}
+
+	Context("when the callback presents the wrong state", func() {
+		It("rejects the request instead of accepting a forged code", func() {
+			sess, err := gexec.Start(flyCmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			port := loopbackPort(sess)
+
+			resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%s/auth/callback?code=stolen&state=wrong", port))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+
+			sess.Kill()
+		})
+	})
+
+	Context("when the callback presents an Origin for a different host", func() {
+		It("rejects the request", func() {
+			sess, err := gexec.Start(flyCmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			port := loopbackPort(sess)
+
+			req, err := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%s/auth/callback?code=foo&state=bar", port), nil)
+			Expect(err).ToNot(HaveOccurred())
+			req.Header.Set("Origin", (&url.URL{Scheme: "http", Host: "evil.example.com"}).String())
+
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+
+			sess.Kill()
+		})
+	})
+
+	Context("when the callback presents a matching state but no Referer or Origin", func() {
+		// This is the normal shape of a real login: the ATC is served over
+		// HTTPS and this loopback callback is plain HTTP, so the browser
+		// drops Referer on that downgrade and never sends Origin on a
+		// top-level GET redirect. Both headers being absent must not be
+		// treated as an attack, or every real login would 403.
+		It("accepts the request", func() {
+			sess, err := gexec.Start(flyCmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			port := loopbackPort(sess)
+			state := loginState(sess)
+
+			resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%s/auth/callback?code=real-code&state=%s", port, state))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).ToNot(Equal(http.StatusForbidden))
+
+			sess.Kill()
+		})
+	})
+
+	Context("when the callback presents a valid code and state", func() {
+		BeforeEach(func() {
+			atcServer.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/sky/issuer/token"),
+					ghttp.VerifyFormKV("grant_type", "authorization_code"),
+					ghttp.VerifyFormKV("code", "real-code"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+						"token_type":   "Bearer",
+						"access_token": "some-access-token",
+					}),
+				),
+			)
+		})
+
+		It("exchanges the code for a token and saves the target", func() {
+			sess, err := gexec.Start(flyCmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			port := loopbackPort(sess)
+			state := loginState(sess)
+
+			resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%s/auth/callback?code=real-code&state=%s", port, state))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).ToNot(Equal(http.StatusForbidden))
+
+			Eventually(sess.Out).Should(gbytes.Say("target saved"))
+			Eventually(sess).Should(gexec.Exit(0))
+		})
+	})
+})
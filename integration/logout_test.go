@@ -0,0 +1,112 @@
+package integration_test
+
+import (
+	"net/http"
+	"os/exec"
+	"sync"
+
+	"github.com/concourse/fly/rc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("fly logout --all", func() {
+	var (
+		revokeMu    sync.Mutex
+		revokeCalls int
+	)
+
+	BeforeEach(func() {
+		revokeCalls = 0
+
+		// logout --all revokes all four targets concurrently, so the route
+		// has to handle repeated, out-of-order hits rather than a fixed
+		// AppendHandlers sequence.
+		atcServer.RouteToHandler("POST", "/sky/token/revoke", ghttp.CombineHandlers(
+			ghttp.VerifyFormKV("token", "some-token"),
+			ghttp.VerifyFormKV("token_type_hint", "access_token"),
+			func(w http.ResponseWriter, r *http.Request) {
+				revokeMu.Lock()
+				revokeCalls++
+				revokeMu.Unlock()
+			},
+		))
+
+		for _, name := range []rc.TargetName{"target-a", "target-b", "target-c", "target-d"} {
+			err := rc.SaveTarget(
+				name,
+				atcServer.URL(),
+				true,
+				"main",
+				&rc.TargetToken{Type: "Bearer", Value: "some-token"},
+				"",
+			)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	})
+
+	It("removes every target, even when several are logged out concurrently", func() {
+		flyCmd := exec.Command(flyPath, "logout", "--all")
+
+		sess, err := gexec.Start(flyCmd, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(sess).Should(gexec.Exit(0))
+
+		remaining, err := rc.LoadTargets()
+		Expect(err).ToNot(HaveOccurred())
+
+		// Regression guard: concurrent logouts used to race on flyrc's
+		// unsynchronized load-modify-save, so whichever goroutine saved
+		// last would silently resurrect every target that finished first.
+		Expect(remaining).To(BeEmpty())
+
+		revokeMu.Lock()
+		defer revokeMu.Unlock()
+		Expect(revokeCalls).To(Equal(4))
+	})
+})
+
+var _ = Describe("fly logout --expired", func() {
+	BeforeEach(func() {
+		// The ATC accepts only "valid-token"; every other token gets a 401
+		// from the UserInfo probe, as an invalidated session would.
+		atcServer.RouteToHandler("GET", "/sky/userinfo", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "Bearer valid-token" {
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(`{"sub":"some-user"}`))
+				return
+			}
+
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+		atcServer.RouteToHandler("POST", "/sky/token/revoke", ghttp.RespondWith(http.StatusOK, ""))
+
+		for name, value := range map[rc.TargetName]string{"still-valid": "valid-token", "invalidated": "invalidated-token"} {
+			err := rc.SaveTarget(
+				name,
+				atcServer.URL(),
+				true,
+				"main",
+				&rc.TargetToken{Type: "Bearer", Value: value},
+				"",
+			)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	})
+
+	It("only removes targets the server has already invalidated", func() {
+		flyCmd := exec.Command(flyPath, "logout", "--expired")
+
+		sess, err := gexec.Start(flyCmd, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(sess).Should(gexec.Exit(0))
+
+		remaining, err := rc.LoadTargets()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remaining).To(HaveKey(rc.TargetName("still-valid")))
+		Expect(remaining).ToNot(HaveKey(rc.TargetName("invalidated")))
+	})
+})
@@ -0,0 +1,165 @@
+package integration_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("fly set-team --config", func() {
+	var configPath string
+
+	AfterEach(func() {
+		if configPath != "" {
+			os.Remove(configPath)
+		}
+	})
+
+	writeConfig := func(contents string) string {
+		f, err := ioutil.TempFile("", "team-auth-*.yml")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = f.WriteString(contents)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		return f.Name()
+	}
+
+	Context("applying a config file", func() {
+		BeforeEach(func() {
+			configPath = writeConfig(`
+roles:
+  owner:
+    users:
+      - local:brock-samson
+    groups: []
+`)
+
+			atcServer.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PUT", "/api/v1/teams/venture"),
+					ghttp.RespondWithJSONEncoded(http.StatusCreated, struct {
+						Name string `json:"name"`
+						ID   int    `json:"id"`
+					}{"venture", 8}),
+				),
+			)
+		})
+
+		It("reads the auth spec from the file instead of requiring flags", func() {
+			flyCmd := exec.Command(flyPath, "-t", targetName, "set-team", "--team-name", "venture", "--config", configPath, "--non-interactive")
+
+			sess, err := gexec.Start(flyCmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(sess.Out).Should(gbytes.Say("  - local:brock-samson"))
+			Eventually(sess).Should(gexec.Exit(0))
+		})
+	})
+
+	Context("applying a config file with member and viewer roles", func() {
+		BeforeEach(func() {
+			configPath = writeConfig(`
+roles:
+  owner:
+    users:
+      - local:brock-samson
+    groups: []
+  member:
+    users: []
+    groups:
+      - github:venture-industries
+  viewer:
+    users:
+      - local:dr-venkman
+    groups: []
+`)
+
+			atcServer.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PUT", "/api/v1/teams/venture"),
+					ghttp.RespondWithJSONEncoded(http.StatusCreated, struct {
+						Name string `json:"name"`
+						ID   int    `json:"id"`
+					}{"venture", 8}),
+				),
+			)
+		})
+
+		It("shows every role the config grants, not just owner", func() {
+			flyCmd := exec.Command(flyPath, "-t", targetName, "set-team", "--team-name", "venture", "--config", configPath, "--non-interactive")
+
+			sess, err := gexec.Start(flyCmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(sess.Out).Should(gbytes.Say("role: member"))
+			Eventually(sess.Out).Should(gbytes.Say("github:venture-industries"))
+			Eventually(sess.Out).Should(gbytes.Say("role: owner"))
+			Eventually(sess.Out).Should(gbytes.Say("local:brock-samson"))
+			Eventually(sess.Out).Should(gbytes.Say("role: viewer"))
+			Eventually(sess.Out).Should(gbytes.Say("local:dr-venkman"))
+			Eventually(sess).Should(gexec.Exit(0))
+		})
+	})
+
+	Context("a config file that grants nobody anything", func() {
+		BeforeEach(func() {
+			configPath = writeConfig(`
+roles:
+  owner:
+    users: []
+    groups: []
+`)
+		})
+
+		It("refuses to apply it without --no-really-i-dont-want-any-auth", func() {
+			flyCmd := exec.Command(flyPath, "-t", targetName, "set-team", "--team-name", "venture", "--config", configPath, "--non-interactive")
+
+			sess, err := gexec.Start(flyCmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(sess.Err).Should(gbytes.Say("no auth methods configured! to continue, run:"))
+			Eventually(sess).Should(gexec.Exit(1))
+		})
+	})
+
+	Context("--dry-run", func() {
+		BeforeEach(func() {
+			configPath = writeConfig(`
+roles:
+  owner:
+    users:
+      - local:brock-samson
+    groups: []
+`)
+
+			atcServer.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v1/teams/venture"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, struct {
+						Name string `json:"name"`
+						ID   int    `json:"id"`
+					}{"venture", 8}),
+				),
+			)
+		})
+
+		It("prints the diff and exits 0 without PUTting anything", func() {
+			flyCmd := exec.Command(flyPath, "-t", targetName, "set-team", "--team-name", "venture", "--config", configPath, "--dry-run")
+
+			sess, err := gexec.Start(flyCmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(sess.Out).Should(gbytes.Say(`\+ user local:brock-samson`))
+			Eventually(sess).Should(gexec.Exit(0))
+		})
+	})
+})
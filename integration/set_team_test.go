@@ -33,7 +33,7 @@ var _ = Describe("Fly CLI", func() {
 		fmt.Fprintf(stdin, "n\n")
 	}
 
-	FDescribe("flag validation", func() {
+	Describe("flag validation", func() {
 
 		Describe("no auth", func() {
 			Context("auth flag not provided", func() {
@@ -0,0 +1,60 @@
+package integration_test
+
+import (
+	"net/http"
+	"os/exec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("fly status", func() {
+	var flyCmd *exec.Cmd
+
+	JustBeforeEach(func() {
+		flyCmd = exec.Command(flyPath, "-t", targetName, "status")
+	})
+
+	Context("when the ATC accepts the stored token", func() {
+		BeforeEach(func() {
+			atcServer.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/sky/userinfo"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+						"sub": "some-user",
+					}),
+				),
+			)
+		})
+
+		It("reports logged in, without inspecting the token itself", func() {
+			sess, err := gexec.Start(flyCmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(sess.Out).Should(gbytes.Say("logged in successfully"))
+			Eventually(sess).Should(gexec.Exit(0))
+		})
+	})
+
+	Context("when the ATC rejects the stored token", func() {
+		BeforeEach(func() {
+			atcServer.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/sky/userinfo"),
+					ghttp.RespondWith(http.StatusUnauthorized, ""),
+				),
+			)
+		})
+
+		It("reports logged out, including for opaque (non-JWT) tokens", func() {
+			sess, err := gexec.Start(flyCmd, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(sess.Out).Should(gbytes.Say("logged out"))
+			Eventually(sess).Should(gexec.Exit(1))
+		})
+	})
+})
@@ -0,0 +1,142 @@
+package rc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// credentialEntry is the JSON schema docker-credential-* helpers speak,
+// reused here so fly can delegate to the same family of helpers
+// (osxkeychain, secret-service, pass, a Vault-backed helper, ...) instead of
+// writing bearer tokens into ~/.flyrc in plaintext.
+type credentialEntry struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// credentialStore shells out to `fly-credential-<name>` to store, fetch, and
+// erase target tokens, the same way docker delegates to `docker-credential-*`
+// for its credsStore config.
+type credentialStore struct {
+	helperName string
+}
+
+func newCredentialStore(name string) *credentialStore {
+	return &credentialStore{helperName: name}
+}
+
+func (s *credentialStore) helperCommand() string {
+	return "fly-credential-" + s.helperName
+}
+
+func (s *credentialStore) store(name TargetName, token *TargetToken) error {
+	secret, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	entry := credentialEntry{
+		ServerURL: credentialServerURL(name),
+		Secret:    string(secret),
+	}
+
+	return s.run("store", entry, nil)
+}
+
+func (s *credentialStore) get(name TargetName) (*TargetToken, error) {
+	var entry credentialEntry
+
+	err := s.run("get", credentialEntry{ServerURL: credentialServerURL(name)}, &entry)
+	if err != nil {
+		return nil, err
+	}
+
+	var token TargetToken
+	err = json.Unmarshal([]byte(entry.Secret), &token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (s *credentialStore) erase(name TargetName) error {
+	return s.run("erase", credentialEntry{ServerURL: credentialServerURL(name)}, nil)
+}
+
+// list reports the ServerURL of every credential the helper holds, so `fly
+// targets` can mark which saved targets actually have a token delegated to
+// it (as opposed to one that was never stored, or was erased out-of-band).
+func (s *credentialStore) list() (map[string]bool, error) {
+	stdout, err := s.exec("list", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]string
+	err = json.Unmarshal(stdout, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	serverURLs := make(map[string]bool, len(entries))
+	for serverURL := range entries {
+		serverURLs[serverURL] = true
+	}
+
+	return serverURLs, nil
+}
+
+func (s *credentialStore) run(subcommand string, in credentialEntry, out *credentialEntry) error {
+	var stdin []byte
+
+	if subcommand == "get" || subcommand == "erase" {
+		stdin = []byte(in.ServerURL)
+	} else {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		stdin = encoded
+	}
+
+	stdout, err := s.exec(subcommand, stdin)
+	if err != nil {
+		return err
+	}
+
+	if out != nil {
+		return json.Unmarshal(stdout, out)
+	}
+
+	return nil
+}
+
+// exec invokes `fly-credential-<name> <subcommand>`, feeding it stdin and
+// returning its stdout, the same plumbing every subcommand (store, get,
+// erase, list) shares.
+func (s *credentialStore) exec(subcommand string, stdin []byte) ([]byte, error) {
+	cmd := exec.Command(s.helperCommand(), subcommand)
+	cmd.Stdin = bytes.NewReader(stdin)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %s", s.helperCommand(), subcommand, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func credentialServerURL(name TargetName) string {
+	return "fly-target://" + string(name)
+}
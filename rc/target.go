@@ -0,0 +1,127 @@
+package rc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/concourse/go-concourse/concourse"
+)
+
+// Team is the minimal team handle fly needs once it has a Target: enough to
+// know which team it's talking to.
+type Team interface {
+	Name() string
+}
+
+type team struct {
+	name string
+}
+
+func (t *team) Name() string { return t.name }
+
+// Target is an authenticated (or about-to-be-authenticated) handle on a
+// Concourse ATC, as named in the flyrc.
+type Target interface {
+	Client() concourse.Client
+	Team() Team
+	CACert() string
+	Token() *TargetToken
+	HTTPClient() *http.Client
+	ValidateWithWarningOnly() error
+}
+
+type target struct {
+	teamName   string
+	client     concourse.Client
+	caCert     string
+	token      *TargetToken
+	httpClient *http.Client
+}
+
+// NewUnauthenticatedTarget builds a Target for an ATC that fly hasn't logged
+// in to yet, e.g. while running `fly login`.
+func NewUnauthenticatedTarget(
+	name TargetName,
+	url string,
+	teamName string,
+	insecure bool,
+	caCert string,
+	verbose bool,
+) (Target, error) {
+	if teamName == "" {
+		return nil, errors.New("team name must be specified")
+	}
+
+	httpClient, err := HTTPClientFor(insecure, caCert)
+	if err != nil {
+		return nil, err
+	}
+
+	return &target{
+		teamName:   teamName,
+		client:     concourse.NewClient(url, httpClient, verbose),
+		caCert:     caCert,
+		httpClient: httpClient,
+	}, nil
+}
+
+func (t *target) Client() concourse.Client {
+	return t.client
+}
+
+func (t *target) Team() Team {
+	return &team{name: t.teamName}
+}
+
+func (t *target) CACert() string {
+	return t.caCert
+}
+
+// Token returns the bearer token fly authenticated this target with, or nil
+// for a target that hasn't logged in yet.
+func (t *target) Token() *TargetToken {
+	return t.token
+}
+
+// HTTPClient returns an http.Client configured with this target's TLS
+// settings, for callers that need to talk to an ATC endpoint directly
+// rather than through Client(), e.g. revoking a token at /sky/token/revoke.
+func (t *target) HTTPClient() *http.Client {
+	return t.httpClient
+}
+
+// ValidateWithWarningOnly pings the ATC to make sure the target is reachable,
+// but only warns (rather than fails) when it isn't, since a target may be
+// unreachable for reasons unrelated to auth (e.g. first-time login to an ATC
+// behind a VPN that isn't up yet).
+func (t *target) ValidateWithWarningOnly() error {
+	_, err := t.client.UserInfo()
+	if err != nil {
+		fmt.Println("warning: couldn't reach the given Concourse URL to validate it; continuing anyway")
+	}
+
+	return nil
+}
+
+// HTTPClientFor builds an http.Client configured with the given TLS
+// verification/CA settings, for callers that need to talk to an ATC's
+// endpoints directly rather than through a concourse.Client, e.g. the
+// device authorization grant's polling requests.
+func HTTPClientFor(insecure bool, caCert string) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+
+	if caCert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(caCert)) {
+			return nil, errors.New("invalid CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
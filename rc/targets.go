@@ -0,0 +1,389 @@
+package rc
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/concourse/go-concourse/concourse"
+	"golang.org/x/oauth2"
+	"gopkg.in/yaml.v2"
+)
+
+// flyrcMu guards every access to the flyrc. ioutil.WriteFile truncates the
+// file before writing its new contents, so a bare read racing a write can
+// observe a partially-written file, not just stale data -- read-only
+// callers (LoadTargets, CredentialStoreBackedTargets, the initial read in
+// LoadTargetWithInsecure) take an RLock, while callers that load, mutate,
+// and save again (SaveTarget, RemoveTarget, SetCredentialStore, the refresh
+// path in LoadTargetWithInsecure) take the write Lock for the whole cycle --
+// otherwise concurrent callers (e.g. `fly logout --all`) race: each reads
+// the whole file, edits its own copy, and writes the whole file back, so
+// whichever save lands last silently resurrects every edit that finished
+// earlier.
+var flyrcMu sync.RWMutex
+
+// TargetName is the name fly targets are referred to by on the command line,
+// e.g. the "ci" in `fly -t ci ...`.
+type TargetName string
+
+// TargetProps is the on-disk (flyrc) representation of a target.
+type TargetProps struct {
+	API      string       `yaml:"api"`
+	TeamName string       `yaml:"team"`
+	Insecure bool         `yaml:"insecure,omitempty"`
+	Token    *TargetToken `yaml:"token,omitempty"`
+	CACert   string       `yaml:"ca_cert,omitempty"`
+}
+
+type flyrc struct {
+	Targets map[TargetName]TargetProps `yaml:"targets"`
+
+	// CredentialStore is the name of a fly-credential-<name> helper that, if
+	// set, fly delegates token storage to instead of writing tokens into
+	// this file. See SetCredentialStore.
+	CredentialStore string `yaml:"credential_store,omitempty"`
+}
+
+func flyrcPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".flyrc"), nil
+}
+
+func loadFlyrc() (flyrc, error) {
+	var rc flyrc
+
+	path, err := flyrcPath()
+	if err != nil {
+		return rc, err
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return flyrc{Targets: map[TargetName]TargetProps{}}, nil
+		}
+
+		return rc, err
+	}
+
+	err = yaml.Unmarshal(content, &rc)
+	if rc.Targets == nil {
+		rc.Targets = map[TargetName]TargetProps{}
+	}
+
+	return rc, err
+}
+
+func saveFlyrc(rc flyrc) error {
+	path, err := flyrcPath()
+	if err != nil {
+		return err
+	}
+
+	content, err := yaml.Marshal(rc)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, content, 0600)
+}
+
+// SaveTarget persists the given target and token under name in the flyrc,
+// overwriting whatever was previously stored there. If a credential_store
+// helper is configured, the token is delegated to it instead of being
+// written into the flyrc in plaintext.
+func SaveTarget(
+	name TargetName,
+	url string,
+	insecure bool,
+	teamName string,
+	token *TargetToken,
+	caCert string,
+) error {
+	flyrcMu.Lock()
+	defer flyrcMu.Unlock()
+
+	rc, err := loadFlyrc()
+	if err != nil {
+		return err
+	}
+
+	props := TargetProps{
+		API:      url,
+		TeamName: teamName,
+		Insecure: insecure,
+		CACert:   caCert,
+	}
+
+	if rc.CredentialStore != "" {
+		err = newCredentialStore(rc.CredentialStore).store(name, token)
+		if err != nil {
+			return err
+		}
+	} else {
+		props.Token = token
+	}
+
+	rc.Targets[name] = props
+
+	return saveFlyrc(rc)
+}
+
+// RemoveTarget deletes name from the flyrc, along with its credential-store
+// entry if one was configured.
+func RemoveTarget(name TargetName) error {
+	flyrcMu.Lock()
+	defer flyrcMu.Unlock()
+
+	rc, err := loadFlyrc()
+	if err != nil {
+		return err
+	}
+
+	if rc.CredentialStore != "" {
+		err = newCredentialStore(rc.CredentialStore).erase(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	delete(rc.Targets, name)
+
+	return saveFlyrc(rc)
+}
+
+// SetCredentialStore records the name of a fly-credential-<name> helper for
+// future SaveTarget/LoadTargetWithInsecure calls to delegate token storage
+// to, e.g. "osxkeychain" invoking fly-credential-osxkeychain.
+func SetCredentialStore(name string) error {
+	flyrcMu.Lock()
+	defer flyrcMu.Unlock()
+
+	rc, err := loadFlyrc()
+	if err != nil {
+		return err
+	}
+
+	rc.CredentialStore = name
+
+	return saveFlyrc(rc)
+}
+
+// LoadTargets returns every target currently saved in the flyrc, keyed by
+// name, for commands like `fly targets` that need to enumerate them.
+func LoadTargets() (map[TargetName]TargetProps, error) {
+	flyrcMu.RLock()
+	defer flyrcMu.RUnlock()
+
+	rc, err := loadFlyrc()
+	if err != nil {
+		return nil, err
+	}
+
+	return rc.Targets, nil
+}
+
+// CredentialStoreBackedTargets reports which of the flyrc's saved targets have their
+// token delegated to the configured credential_store helper, by asking the
+// helper to list everything it holds. It returns an empty, nil-error result
+// if no credential_store is configured, since then nothing is helper-backed.
+func CredentialStoreBackedTargets() (map[TargetName]bool, error) {
+	flyrcMu.RLock()
+	defer flyrcMu.RUnlock()
+
+	rc, err := loadFlyrc()
+	if err != nil {
+		return nil, err
+	}
+
+	if rc.CredentialStore == "" {
+		return map[TargetName]bool{}, nil
+	}
+
+	serverURLs, err := newCredentialStore(rc.CredentialStore).list()
+	if err != nil {
+		return nil, err
+	}
+
+	backed := make(map[TargetName]bool, len(rc.Targets))
+	for name := range rc.Targets {
+		if serverURLs[credentialServerURL(name)] {
+			backed[name] = true
+		}
+	}
+
+	return backed, nil
+}
+
+// LoadTarget loads a previously-saved target from the flyrc using whatever
+// insecure/CA-cert settings were saved alongside it.
+func LoadTarget(name TargetName, verbose bool) (Target, error) {
+	return LoadTargetWithInsecure(name, "", false, "", verbose)
+}
+
+// LoadTargetWithInsecure loads a previously-saved target from the flyrc,
+// transparently refreshing its token via the OAuth2 refresh_token grant if
+// it's expired and a refresh token was stored alongside it.
+func LoadTargetWithInsecure(
+	name TargetName,
+	teamNameOverride string,
+	insecureOverride bool,
+	caCertOverride string,
+	verbose bool,
+) (Target, error) {
+	rc, err := func() (flyrc, error) {
+		flyrcMu.RLock()
+		defer flyrcMu.RUnlock()
+
+		return loadFlyrc()
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	props, found := rc.Targets[name]
+	if !found {
+		return nil, errUnknownTarget(name)
+	}
+
+	if teamNameOverride != "" {
+		props.TeamName = teamNameOverride
+	}
+
+	insecure := props.Insecure || insecureOverride
+
+	caCert := props.CACert
+	if caCertOverride != "" {
+		caCert = caCertOverride
+	}
+
+	if rc.CredentialStore != "" {
+		props.Token, err = newCredentialStore(rc.CredentialStore).get(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if props.Token != nil && props.Token.RefreshToken != "" && tokenExpired(props.Token) {
+		refreshed, err := refreshToken(props.API, insecure, caCert, props.Token)
+		if err != nil {
+			return nil, err
+		}
+
+		props.Token = refreshed
+
+		if rc.CredentialStore != "" {
+			err = newCredentialStore(rc.CredentialStore).store(name, refreshed)
+		} else {
+			err = func() error {
+				flyrcMu.Lock()
+				defer flyrcMu.Unlock()
+
+				latest, err := loadFlyrc()
+				if err != nil {
+					return err
+				}
+
+				latest.Targets[name] = props
+
+				return saveFlyrc(latest)
+			}()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	httpClient, err := HTTPClientFor(insecure, caCert)
+	if err != nil {
+		return nil, err
+	}
+
+	authedHTTPClient := httpClient
+	if props.Token != nil {
+		authedHTTPClient = tokenAuthHTTPClient(httpClient, props.Token)
+	}
+
+	client := concourse.NewClient(props.API, authedHTTPClient, verbose)
+
+	return &target{
+		teamName:   props.TeamName,
+		client:     client,
+		caCert:     caCert,
+		token:      props.Token,
+		httpClient: httpClient,
+	}, nil
+}
+
+// tokenAuthHTTPClient wraps client so every request carries the target's
+// bearer token, without requiring the concourse.Client to know about tokens
+// at all.
+func tokenAuthHTTPClient(client *http.Client, token *TargetToken) *http.Client {
+	return &http.Client{
+		Transport: tokenTransport{
+			token:     token,
+			transport: client.Transport,
+		},
+	}
+}
+
+type tokenTransport struct {
+	token     *TargetToken
+	transport http.RoundTripper
+}
+
+func (t tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", t.token.Type+" "+t.token.Value)
+	return t.transport.RoundTrip(req)
+}
+
+func tokenExpired(token *TargetToken) bool {
+	if token.Expiry.IsZero() {
+		return false
+	}
+
+	return time.Now().After(token.Expiry.Add(-30 * time.Second))
+}
+
+func refreshToken(api string, insecure bool, caCert string, token *TargetToken) (*TargetToken, error) {
+	httpClient, err := HTTPClientFor(insecure, caCert)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthConfig := oauth2.Config{
+		Endpoint: oauth2.Endpoint{
+			TokenURL: api + "/sky/issuer/token",
+		},
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+
+	newToken, err := oauthConfig.TokenSource(ctx, &oauth2.Token{
+		RefreshToken: token.RefreshToken,
+	}).Token()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TargetToken{
+		Type:         newToken.TokenType,
+		Value:        newToken.AccessToken,
+		RefreshToken: newToken.RefreshToken,
+		Expiry:       newToken.Expiry,
+	}, nil
+}
+
+func errUnknownTarget(name TargetName) error {
+	return errors.New("unknown target: " + string(name))
+}
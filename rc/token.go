@@ -0,0 +1,17 @@
+package rc
+
+import "time"
+
+// TargetToken is the bearer token fly stores in the flyrc for a target,
+// along with enough information to keep it fresh. RefreshToken and Expiry
+// are optional: they're only present for targets that authenticated through
+// a grant that issues a refresh token (e.g. the browser or device flow).
+// fly no longer assumes Value is a JWT it can inspect locally -- some
+// Concourse/Dex deployments issue opaque tokens -- so the only way to know
+// whether a token is still good is to ask the ATC.
+type TargetToken struct {
+	Type         string    `yaml:"type"`
+	Value        string    `yaml:"value"`
+	RefreshToken string    `yaml:"refresh_token,omitempty"`
+	Expiry       time.Time `yaml:"expiry,omitempty"`
+}